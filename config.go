@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes how a committee routes and formats its export, loaded
+// from a YAML or TOML file so every chapter/section can use the tool
+// without recompiling it (see getConfiguration's previous hard-coded
+// Automation och Mekatronik setup).
+type Config struct {
+	// DefaultResultUnit is the result unit that "verifikat" rows without a
+	// recognised owner fall back to, e.g. "Ztyret".
+	DefaultResultUnit string `yaml:"defaultResultUnit" toml:"defaultResultUnit"`
+	// Aggregations maps a result unit name to the result unit it should be
+	// exported alongside, e.g. "Revisorer" -> "Ztyret" for an N-to-1 roll-up.
+	Aggregations map[string]string `yaml:"aggregations" toml:"aggregations"`
+	// OutputDirs overrides the export directory name for a result unit;
+	// unlisted units use their own name.
+	OutputDirs map[string]string `yaml:"outputDirs" toml:"outputDirs"`
+	// FilenameTemplates overrides the output filename for a result unit.
+	// "{ext}" is replaced with the dump format's file extension; unlisted
+	// units get "13. Verifikatlista.{ext}".
+	FilenameTemplates map[string]string `yaml:"filenameTemplates" toml:"filenameTemplates"`
+	// ColumnWidths overrides the XLSX column widths. Unused while the XLSX
+	// output goes through xlsxStreamWriter, which the underlying streaming
+	// library doesn't expose column styling for; kept so a future
+	// non-streaming XLSX writer (or a library upgrade) can pick it back up.
+	ColumnWidths []float64 `yaml:"columnWidths" toml:"columnWidths"`
+}
+
+// defaultConfigSearchPaths is checked, in order, when --config isn't given.
+func defaultConfigSearchPaths() []string {
+	paths := []string{"verifications.yaml", "verifications.yml", "verifications.toml"}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		paths = append(paths,
+			path.Join(xdg, "visma-verifications", "config.yaml"),
+			path.Join(xdg, "visma-verifications", "config.toml"),
+		)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths,
+			path.Join(home, ".config", "visma-verifications", "config.yaml"),
+			path.Join(home, ".config", "visma-verifications", "config.toml"),
+		)
+	}
+	return paths
+}
+
+// defaultConfig is the config used when no --config is given and none of
+// defaultConfigSearchPaths exists, so the tool keeps working for existing
+// users who never set up a verifications.yaml/.toml. It's the Automation
+// och Mekatronik routing getConfiguration used to hard-code, now just data;
+// other chapters still override it with their own --config.
+func defaultConfig() *Config {
+	return &Config{
+		DefaultResultUnit: "Ztyret",
+		Aggregations: map[string]string{
+			"IntrezzeK": "Ztyret",
+			"Revisorer": "Ztyret",
+			"VB":        "Ztyret",
+			"ZKK":       "Ztyret",
+			"Zpel":      "Ztyret",
+			"Ztyret":    "Ztyret",
+			"ZÅG":       "Ztyret",
+			"WebGroup":  "Ztyret",
+		},
+	}
+}
+
+// loadConfiguration reads and validates the routing config. An explicit
+// configPath is read as-is; otherwise defaultConfigSearchPaths is searched
+// in order and the first existing file wins, falling back to defaultConfig
+// when none exists.
+func loadConfiguration(configPath string) (*Config, error) {
+	if configPath == "" {
+		for _, candidate := range defaultConfigSearchPaths() {
+			if _, err := os.Stat(candidate); err == nil {
+				configPath = candidate
+				break
+			}
+		}
+	}
+	if configPath == "" {
+		return defaultConfig(), nil
+	}
+
+	cfg := &Config{}
+	switch strings.ToLower(filepath.Ext(configPath)) {
+	case ".yaml", ".yml":
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", configPath, err)
+		}
+	case ".toml":
+		if _, err := toml.DecodeFile(configPath, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", configPath, err)
+		}
+	default:
+		return nil, fmt.Errorf("unrecognised config extension for %s, expected .yaml or .toml", configPath)
+	}
+
+	if cfg.DefaultResultUnit == "" {
+		return nil, fmt.Errorf("%s does not set defaultResultUnit", configPath)
+	}
+	return cfg, nil
+}
+
+// isKnownResultUnit reports whether name is a result unit this config
+// actually routes, i.e. it's the default, something Aggregations maps from,
+// or something Aggregations maps to. checkVerifikat uses this to flag an
+// owner that doesn't mean anything to this config, as opposed to one that's
+// merely missing.
+func (c *Config) isKnownResultUnit(name string) bool {
+	if name == c.DefaultResultUnit {
+		return true
+	}
+	for from, to := range c.Aggregations {
+		if name == from || name == to {
+			return true
+		}
+	}
+	return false
+}
+
+// outputDirFor returns the export directory name for a result unit,
+// honouring OutputDirs when the unit has an override.
+func (c *Config) outputDirFor(resultUnit string) string {
+	if dir, ok := c.OutputDirs[resultUnit]; ok {
+		return dir
+	}
+	return resultUnit
+}
+
+// filenameFor returns the output filename for a result unit, honouring
+// FilenameTemplates when the unit has an override.
+func (c *Config) filenameFor(resultUnit string, ext string) string {
+	template, ok := c.FilenameTemplates[resultUnit]
+	if !ok {
+		template = "13. Verifikatlista.{ext}"
+	}
+	return strings.ReplaceAll(template, "{ext}", ext)
+}
+
+func mustLoadConfiguration(configPath string) *Config {
+	cfg, err := loadConfiguration(configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %s", err)
+	}
+	return cfg
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigurationYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "verifications.yaml")
+	data := "defaultResultUnit: Ztyret\naggregations:\n  Revisorer: Ztyret\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	cfg, err := loadConfiguration(path)
+	if err != nil {
+		t.Fatalf("loadConfiguration: %v", err)
+	}
+	if cfg.DefaultResultUnit != "Ztyret" {
+		t.Errorf("DefaultResultUnit = %q, want %q", cfg.DefaultResultUnit, "Ztyret")
+	}
+	if cfg.Aggregations["Revisorer"] != "Ztyret" {
+		t.Errorf("Aggregations[Revisorer] = %q, want %q", cfg.Aggregations["Revisorer"], "Ztyret")
+	}
+}
+
+func TestLoadConfigurationTOML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "verifications.toml")
+	data := "defaultResultUnit = \"Ztyret\"\n\n[aggregations]\nRevisorer = \"Ztyret\"\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	cfg, err := loadConfiguration(path)
+	if err != nil {
+		t.Fatalf("loadConfiguration: %v", err)
+	}
+	if cfg.DefaultResultUnit != "Ztyret" {
+		t.Errorf("DefaultResultUnit = %q, want %q", cfg.DefaultResultUnit, "Ztyret")
+	}
+	if cfg.Aggregations["Revisorer"] != "Ztyret" {
+		t.Errorf("Aggregations[Revisorer] = %q, want %q", cfg.Aggregations["Revisorer"], "Ztyret")
+	}
+}
+
+func TestLoadConfigurationUnrecognisedExtension(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "verifications.json")
+	if err := os.WriteFile(path, []byte("{}"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := loadConfiguration(path); err == nil {
+		t.Error("loadConfiguration with a .json path = nil error, want one")
+	}
+}
+
+func TestLoadConfigurationMissingDefaultResultUnit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "verifications.yaml")
+	if err := os.WriteFile(path, []byte("aggregations:\n  Revisorer: Ztyret\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := loadConfiguration(path); err == nil {
+		t.Error("loadConfiguration with no defaultResultUnit = nil error, want one")
+	}
+}
+
+func TestLoadConfigurationFallsBackToDefaultConfig(t *testing.T) {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd: %v", err)
+	}
+	defer os.Chdir(cwd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Chdir: %v", err)
+	}
+
+	cfg, err := loadConfiguration("")
+	if err != nil {
+		t.Fatalf("loadConfiguration: %v", err)
+	}
+	if cfg.DefaultResultUnit != defaultConfig().DefaultResultUnit {
+		t.Errorf("loadConfiguration(\"\") with no search path hits = %q, want the built-in default %q",
+			cfg.DefaultResultUnit, defaultConfig().DefaultResultUnit)
+	}
+}
+
+func TestLoadConfigurationSearchPathPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd: %v", err)
+	}
+	defer os.Chdir(cwd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Chdir: %v", err)
+	}
+
+	// defaultConfigSearchPaths checks verifications.yaml before
+	// verifications.yml; only the .yaml file should be read.
+	if err := os.WriteFile("verifications.yaml", []byte("defaultResultUnit: FromYAML\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := os.WriteFile("verifications.yml", []byte("defaultResultUnit: FromYML\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	cfg, err := loadConfiguration("")
+	if err != nil {
+		t.Fatalf("loadConfiguration: %v", err)
+	}
+	if cfg.DefaultResultUnit != "FromYAML" {
+		t.Errorf("loadConfiguration(\"\") = %q, want the verifications.yaml file to win (%q)", cfg.DefaultResultUnit, "FromYAML")
+	}
+}
+
+func TestIsKnownResultUnit(t *testing.T) {
+	cfg := &Config{
+		DefaultResultUnit: "Ztyret",
+		Aggregations:      map[string]string{"Revisorer": "Ztyret"},
+	}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"Ztyret", true},
+		{"Revisorer", true},
+		{"NotARealUnit", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := cfg.isKnownResultUnit(tt.name); got != tt.want {
+			t.Errorf("isKnownResultUnit(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
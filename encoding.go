@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// inputEncodings maps the --input-encoding flag values to their decoders.
+// "auto" (the default) sniffs the file instead of using a fixed entry.
+var inputEncodings = map[string]encoding.Encoding{
+	"utf8":        encoding.Nop,
+	"utf16le":     unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM),
+	"utf16be":     unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+	"windows1252": charmap.Windows1252,
+	"iso88591":    charmap.ISO8859_1,
+}
+
+// transcodingReader wraps fileReader so csv.NewReader always sees UTF-8.
+// When requested is "auto" the first bytes of the file are sniffed for a
+// BOM or tell-tale non-UTF-8 byte patterns; otherwise requested must name
+// one of the inputEncodings entries.
+func transcodingReader(fileReader io.Reader, requested string) (io.Reader, error) {
+	if requested == "" || requested == "auto" {
+		return autoTranscodingReader(fileReader)
+	}
+	enc, ok := inputEncodings[requested]
+	if !ok {
+		return nil, fmt.Errorf("unknown input encoding %q", requested)
+	}
+	return transform.NewReader(fileReader, enc.NewDecoder()), nil
+}
+
+// autoTranscodingReader sniffs the first bytes of r for a UTF-8/UTF-16 BOM
+// or, failing that, whether the bytes are valid UTF-8 at all. Visma CSV
+// exports without a BOM that contain non-ASCII owner names such as "ZÅG"
+// are Windows-1252, so that's the fallback once UTF-8 is ruled out.
+func autoTranscodingReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	peek, err := br.Peek(512)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case len(peek) >= 3 && peek[0] == 0xEF && peek[1] == 0xBB && peek[2] == 0xBF:
+		if _, err := br.Discard(3); err != nil {
+			return nil, err
+		}
+		return br, nil
+	case len(peek) >= 2 && peek[0] == 0xFF && peek[1] == 0xFE:
+		return transform.NewReader(br, unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewDecoder()), nil
+	case len(peek) >= 2 && peek[0] == 0xFE && peek[1] == 0xFF:
+		return transform.NewReader(br, unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewDecoder()), nil
+	case utf8.Valid(completeRunePrefix(peek)):
+		return br, nil
+	default:
+		return transform.NewReader(br, charmap.Windows1252.NewDecoder()), nil
+	}
+}
+
+// completeRunePrefix trims a trailing incomplete UTF-8 sequence off peek, so
+// a valid multi-byte rune that happens to straddle the end of the peeked
+// buffer doesn't make utf8.Valid wrongly report the whole file as invalid.
+func completeRunePrefix(peek []byte) []byte {
+	n := len(peek)
+	for i := 1; i <= utf8.UTFMax && i <= n; i++ {
+		if peek[n-i]&0xC0 != 0x80 { // an ASCII byte or a multi-byte rune's lead byte
+			if !utf8.FullRune(peek[n-i:]) {
+				return peek[:n-i]
+			}
+			return peek
+		}
+	}
+	return peek
+}
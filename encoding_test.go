@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCompleteRunePrefix(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		want []byte
+	}{
+		{"empty", nil, nil},
+		{"ascii", []byte("hello"), []byte("hello")},
+		{"complete multi-byte rune", []byte("Ztyret ZÅG"), []byte("Ztyret ZÅG")},
+		{"truncated 2-byte rune", []byte("Ztyret Z\xC3"), []byte("Ztyret Z")},
+		{"truncated 3-byte rune", []byte("Ztyret \xE2\x82"), []byte("Ztyret ")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := completeRunePrefix(tt.in)
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("completeRunePrefix(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutoTranscodingReaderUTF8BoundaryRune(t *testing.T) {
+	// A valid UTF-8 "Å" (0xC3 0x85) whose first byte falls on index 511,
+	// the last byte of the 512-byte peek window, used to be mistaken for
+	// invalid UTF-8 (since the peeked slice ends mid-rune) and transcoded
+	// as Windows-1252, corrupting it.
+	prefix := strings.Repeat("a", 511)
+	input := prefix + "ÅG;rest\n"
+
+	r, err := autoTranscodingReader(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("autoTranscodingReader: %v", err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !strings.Contains(string(out), "ÅG") {
+		t.Errorf("autoTranscodingReader corrupted a boundary-straddling UTF-8 rune: got %q", out)
+	}
+}
+
+func TestAutoTranscodingReaderWindows1252Fallback(t *testing.T) {
+	// "Å" in Windows-1252 is the single byte 0xC5, which is invalid UTF-8 on
+	// its own, so the input as a whole isn't valid UTF-8.
+	input := []byte("Ztyret \xC5G;rest\n")
+	r, err := autoTranscodingReader(bytes.NewReader(input))
+	if err != nil {
+		t.Fatalf("autoTranscodingReader: %v", err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !strings.Contains(string(out), "ÅG") {
+		t.Errorf("autoTranscodingReader did not transcode Windows-1252 input, got %q", out)
+	}
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/tealeg/xlsx"
+)
+
+// runPipeline streams the input file straight through to the per-result-unit
+// output files: a producer goroutine reads one record at a time, a splitter
+// goroutine fans completed "verifikat" groups out to per-unit channels, and
+// one writer goroutine per unit flushes rows to disk as they arrive. Memory
+// usage is bounded by the size of a single verifikat, not the whole file, so
+// multi-year exports no longer have to fit in memory at once.
+//
+// sortMode controls the row order within each unit's output (see sortKey)
+// and dryRun, when set, reports the planned row counts and target paths
+// instead of writing anything. Both the log of completed units and the
+// dry-run report are printed sorted by unit name once every writer goroutine
+// has finished, so two runs over the same input diff cleanly regardless of
+// goroutine scheduling.
+//
+// The returned Report holds every validation issue found while splitting
+// (see checkVerifikat); a malformed row or an unbalanced verifikat is
+// recorded there instead of aborting the run.
+func runPipeline(inFile, outDir, inputEncoding, format, sortMode, sieEncoding string, dryRun bool, cfg *Config) (*Report, error) {
+	lineCh := make(chan Line, 256)
+	errCh := make(chan error, 16)
+	report := newReport()
+
+	var wg sync.WaitGroup
+	units := newUnitRegistry(cfg, format, outDir, sortMode, sieEncoding, dryRun, &wg, errCh)
+
+	produce := newProducer(inFile, inputEncoding)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		produce(lineCh, errCh)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		splitStream(lineCh, units, cfg, report)
+		units.closeAll()
+	}()
+
+	wg.Wait()
+	close(errCh)
+
+	var firstErr error
+	for err := range errCh {
+		if firstErr == nil {
+			firstErr = err
+		}
+		log.Printf("Pipeline error: %s", err)
+	}
+	units.printResults()
+	return report, firstErr
+}
+
+// newProducer returns the goroutine body that feeds lineCh, dispatching on
+// the input file extension the same way the old readFile did: SIE 4
+// (.si/.se) files are parsed whole (their #OBJEKT table has to be read
+// before the #TRANS records that reference it can be resolved), everything
+// else is streamed from the CSV reader one record at a time.
+func newProducer(inFile, inputEncoding string) func(out chan<- Line, errCh chan<- error) {
+	switch strings.ToLower(path.Ext(inFile)) {
+	case ".si", ".se":
+		return func(out chan<- Line, errCh chan<- error) {
+			defer close(out)
+			lines, err := readSIE4Lines(inFile)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			for _, l := range lines {
+				out <- l
+			}
+		}
+	default:
+		return func(out chan<- Line, errCh chan<- error) {
+			produceCSVLines(inFile, inputEncoding, out, errCh)
+		}
+	}
+}
+
+// produceCSVLines reads the Visma CSV export one record at a time and
+// pushes each as a Line onto out, so the rest of the pipeline never holds
+// more than a handful of rows in memory.
+func produceCSVLines(inFile, inputEncoding string, out chan<- Line, errCh chan<- error) {
+	defer close(out)
+	fileReader, err := os.Open(inFile)
+	if err != nil {
+		errCh <- fmt.Errorf("failed to open %s: %w", inFile, err)
+		return
+	}
+	defer fileReader.Close()
+
+	transcoded, err := transcodingReader(fileReader, inputEncoding)
+	if err != nil {
+		errCh <- fmt.Errorf("failed to determine the encoding of %s: %w", inFile, err)
+		return
+	}
+	csvReader := csv.NewReader(transcoded)
+	csvReader.LazyQuotes = true
+	csvReader.Comma = ';'
+
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			errCh <- err
+			return
+		}
+		out <- Line(record)
+	}
+}
+
+// splitStream is the streaming equivalent of the old splitFileByResult: it
+// buffers lines until it knows which result units a "verifikat" belongs to
+// (debit/credit lines name the owner in column 5), then hands that buffer
+// to the owning units' writer goroutines, falling back to the configured
+// default result unit when a verifikat names none. The buffer never holds
+// more than one verifikat's worth of lines.
+//
+// A line that doesn't have the expected 8 columns used to abort the whole
+// export with log.Fatal; it's now recorded on report as a "shape" issue and
+// skipped instead, so one bad row doesn't take down the rest of the run.
+func splitStream(lines <-chan Line, units *unitRegistry, cfg *Config, report *Report) {
+	buffer := make([]Line, 0)
+	currentUnits := make(map[string]bool)
+	verID := ""
+	for line := range lines {
+		if len(line) != 8 {
+			report.addIssue(Issue{
+				Verifikat: verID,
+				Kind:      "shape",
+				Message:   fmt.Sprintf("line did not contain 8 elements (got %d)", len(line)),
+			})
+			continue
+		}
+		owner := line[5]
+		switch {
+		case owner != "" && !strings.HasPrefix(owner, "\""):
+			currentUnits[owner] = true
+			buffer = append(buffer, line)
+		case line[0] == "":
+			buffer = append(buffer, line)
+		default:
+			flushVerifikat(units, cfg, report, verID, currentUnits, buffer)
+			currentUnits = make(map[string]bool)
+			buffer = []Line{line}
+			verID = line[0]
+		}
+	}
+	flushVerifikat(units, cfg, report, verID, currentUnits, buffer)
+}
+
+func flushVerifikat(units *unitRegistry, cfg *Config, report *Report, verID string, currentUnits map[string]bool, buffer []Line) {
+	if len(buffer) == 0 {
+		return
+	}
+	report.VerifikatCount++
+	for _, issue := range checkVerifikat(verID, buffer, cfg) {
+		report.addIssue(issue)
+	}
+
+	targets := currentUnits
+	if len(targets) == 0 {
+		targets = map[string]bool{cfg.DefaultResultUnit: true}
+	}
+	for name := range targets {
+		w := units.getOrCreate(name)
+		for _, line := range buffer {
+			w.lines <- line
+		}
+	}
+}
+
+// unitWriter is the channel a result unit's writer goroutine drains.
+type unitWriter struct {
+	lines chan Line
+}
+
+// unitResult records what happened to one result unit, so runPipeline can
+// report every unit in a stable, name-sorted order once all writers finish
+// instead of in whatever order their goroutines happened to complete.
+type unitResult struct {
+	name    string
+	rows    int
+	outFile string
+}
+
+// unitRegistry lazily spins up one writer goroutine per result unit, the
+// first time splitStream names it, and tracks them all in wg so the
+// pipeline can wait for every output file to finish before returning.
+type unitRegistry struct {
+	mu          sync.Mutex
+	units       map[string]*unitWriter
+	results     []unitResult
+	cfg         *Config
+	format      string
+	outDir      string
+	sortMode    string
+	sieEncoding string
+	dryRun      bool
+	wg          *sync.WaitGroup
+	errCh       chan<- error
+}
+
+func newUnitRegistry(cfg *Config, format, outDir, sortMode, sieEncoding string, dryRun bool, wg *sync.WaitGroup, errCh chan<- error) *unitRegistry {
+	return &unitRegistry{
+		units:       make(map[string]*unitWriter),
+		cfg:         cfg,
+		format:      format,
+		outDir:      outDir,
+		sortMode:    sortMode,
+		sieEncoding: sieEncoding,
+		dryRun:      dryRun,
+		wg:          wg,
+		errCh:       errCh,
+	}
+}
+
+func (r *unitRegistry) fail(err error) {
+	r.errCh <- err
+}
+
+func (r *unitRegistry) recordResult(res unitResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, res)
+}
+
+// printResults logs one line per result unit, sorted by name so that
+// running the tool twice over the same input produces an identical,
+// diffable log regardless of writer goroutine scheduling.
+func (r *unitRegistry) printResults() {
+	r.mu.Lock()
+	results := append([]unitResult(nil), r.results...)
+	r.mu.Unlock()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].name < results[j].name })
+	for _, res := range results {
+		if r.dryRun {
+			log.Printf("Would export %d row(s) for %s to %s", res.rows, res.name, res.outFile)
+		} else {
+			log.Printf("Successfully exported result for %s to %s", res.name, res.outFile)
+		}
+	}
+}
+
+func (r *unitRegistry) getOrCreate(name string) *unitWriter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if w, ok := r.units[name]; ok {
+		return w
+	}
+	w := &unitWriter{lines: make(chan Line, 64)}
+	r.units[name] = w
+	r.wg.Add(1)
+	go r.run(name, w)
+	return w
+}
+
+// closeAll closes every unit's lines channel, once splitStream has finished
+// routing and will never call getOrCreate again. Each writer goroutine's
+// range over its channel only ends once it's closed, so without this
+// runPipeline's wg.Wait never returns.
+func (r *unitRegistry) closeAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, w := range r.units {
+		close(w.lines)
+	}
+}
+
+func (r *unitRegistry) run(name string, w *unitWriter) {
+	defer r.wg.Done()
+	ext := extForFormat(r.format)
+
+	var targetDir, filename string
+	if target, ok := r.cfg.Aggregations[name]; ok {
+		targetDir = r.cfg.outputDirFor(target)
+		filename = name + "." + ext
+	} else {
+		targetDir = r.cfg.outputDirFor(name)
+		filename = r.cfg.filenameFor(name, ext)
+	}
+	outFile := path.Join(r.outDir, targetDir, filename)
+
+	if r.dryRun {
+		rows := 0
+		for range w.lines {
+			rows++
+		}
+		r.recordResult(unitResult{name: name, rows: rows, outFile: outFile})
+		return
+	}
+
+	if err := os.MkdirAll(path.Dir(outFile), 0770); err != nil {
+		r.fail(fmt.Errorf("failed to export result for %s: %w", name, err))
+		drainLines(w.lines)
+		return
+	}
+	f, err := os.Create(outFile)
+	if err != nil {
+		r.fail(fmt.Errorf("failed to export result for %s: %w", name, err))
+		drainLines(w.lines)
+		return
+	}
+	sw, err := newStreamWriter(r.format, f, r.cfg.ColumnWidths, r.sieEncoding)
+	if err != nil {
+		r.fail(fmt.Errorf("failed to export result for %s: %w", name, err))
+		_ = f.Close()
+		drainLines(w.lines)
+		return
+	}
+
+	rows := 0
+	for line := range sortedLines(w.lines, r.format, r.sortMode) {
+		if err := sw.WriteLine(line); err != nil {
+			r.fail(fmt.Errorf("failed to export result for %s: %w", name, err))
+		}
+		rows++
+	}
+	if err := sw.Close(); err != nil {
+		r.fail(fmt.Errorf("failed to export result for %s: %w", name, err))
+		return
+	}
+	r.recordResult(unitResult{name: name, rows: rows, outFile: outFile})
+}
+
+// sortedLines returns lines unchanged, in source order, for --sort=source
+// (the default) and for the SIE 4 format, whose #VER/#TRANS grouping relies
+// on that order. For --sort=name/date with csv/xlsx it buffers the whole
+// unit's rows so it can sort them by sortKey, trading the streaming
+// pipeline's O(1)-per-verifikat memory bound for deterministic, diffable
+// row order.
+func sortedLines(in <-chan Line, format, sortMode string) <-chan Line {
+	if sortMode == "" || sortMode == "source" || format == "sie4" {
+		return in
+	}
+	buffered := make([]Line, 0)
+	for line := range in {
+		buffered = append(buffered, line)
+	}
+	sort.SliceStable(buffered, func(i, j int) bool {
+		return sortKey(sortMode, buffered[i]) < sortKey(sortMode, buffered[j])
+	})
+	out := make(chan Line, len(buffered))
+	for _, line := range buffered {
+		out <- line
+	}
+	close(out)
+	return out
+}
+
+// sortKey extracts the column --sort orders rows by: the verifikat date
+// (column 1) for "date", the free-text description (column 2) for "name".
+func sortKey(sortMode string, line Line) string {
+	if len(line) != 8 {
+		return ""
+	}
+	switch sortMode {
+	case "date":
+		return line[1]
+	case "name":
+		return line[2]
+	default:
+		return ""
+	}
+}
+
+func drainLines(lines <-chan Line) {
+	for range lines {
+	}
+}
+
+func extForFormat(format string) string {
+	switch format {
+	case "csv":
+		return "csv"
+	case "sie4":
+		return "si"
+	default:
+		return "xlsx"
+	}
+}
+
+// streamWriter incrementally writes the rows of one result unit's output
+// file, so writeFile never has to hold a whole export in memory.
+type streamWriter interface {
+	WriteLine(line Line) error
+	Close() error
+}
+
+func newStreamWriter(format string, f *os.File, columnWidths []float64, sieEncoding string) (streamWriter, error) {
+	switch format {
+	case "csv":
+		return newCSVStreamWriter(f), nil
+	case "sie4":
+		enc, ok := sieEncodings[sieEncoding]
+		if !ok {
+			return nil, fmt.Errorf("unknown SIE 4 encoding %q, expected cp437 or iso88591", sieEncoding)
+		}
+		return newSIEStreamWriter(f, enc)
+	default:
+		if len(columnWidths) > 0 {
+			return nil, fmt.Errorf("columnWidths is set in the config but has no effect on --format=xlsx, which is always written by the streaming xlsx writer; remove it or switch to --format=csv")
+		}
+		return newXLSXStreamWriter(f)
+	}
+}
+
+// csvStreamWriter wraps csv.Writer, flushing every flushEvery rows instead
+// of buffering the whole file in the writer's internal bufio.Writer.
+type csvStreamWriter struct {
+	f *os.File
+	w *csv.Writer
+	n int
+}
+
+const flushEvery = 200
+
+func newCSVStreamWriter(f *os.File) *csvStreamWriter {
+	w := csv.NewWriter(f)
+	w.Comma = ';'
+	return &csvStreamWriter{f: f, w: w}
+}
+
+func (c *csvStreamWriter) WriteLine(line Line) error {
+	if err := c.w.Write(line); err != nil {
+		return err
+	}
+	c.n++
+	if c.n%flushEvery == 0 {
+		c.w.Flush()
+		return c.w.Error()
+	}
+	return nil
+}
+
+func (c *csvStreamWriter) Close() error {
+	c.w.Flush()
+	if err := c.w.Error(); err != nil {
+		_ = c.f.Close()
+		return err
+	}
+	return c.f.Close()
+}
+
+// xlsxColumnHeaders names the sheet's header row, in the same column order
+// as Line: verifikat number, date, account, debit, credit, result unit.
+// Columns 7 and 8 carry no known meaning in the original Visma export.
+var xlsxColumnHeaders = []string{"Verifikat", "Datum", "Konto", "Debet", "Kredit", "Resultatenhet", "", ""}
+
+// xlsxStreamWriter wraps xlsx.StreamFileBuilder, which writes each row
+// straight to the zip stream instead of building the whole sheet in memory.
+// The streaming writer doesn't support per-column widths, unlike dumpXLSX
+// previously did, since the underlying library fixes the sheet's shape once
+// the stream is built.
+type xlsxStreamWriter struct {
+	f  *os.File
+	sf *xlsx.StreamFile
+}
+
+func newXLSXStreamWriter(f *os.File) (*xlsxStreamWriter, error) {
+	builder := xlsx.NewStreamFileBuilder(f)
+	if err := builder.AddSheet("Sheet1", xlsxColumnHeaders, nil); err != nil {
+		return nil, err
+	}
+	sf, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	return &xlsxStreamWriter{f: f, sf: sf}, nil
+}
+
+func (x *xlsxStreamWriter) WriteLine(line Line) error {
+	return x.sf.Write(line)
+}
+
+func (x *xlsxStreamWriter) Close() error {
+	if err := x.sf.Close(); err != nil {
+		_ = x.f.Close()
+		return err
+	}
+	return x.f.Close()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSortKey(t *testing.T) {
+	line := Line{"1", "2024-08-01", "description", "100.00", "", "Ztyret", "", ""}
+	tests := []struct {
+		sortMode string
+		want     string
+	}{
+		{"date", "2024-08-01"},
+		{"name", "description"},
+		{"source", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := sortKey(tt.sortMode, line); got != tt.want {
+			t.Errorf("sortKey(%q, ...) = %q, want %q", tt.sortMode, got, tt.want)
+		}
+	}
+	if got := sortKey("date", Line{"too", "short"}); got != "" {
+		t.Errorf("sortKey on a malformed line = %q, want \"\"", got)
+	}
+}
+
+func TestRunPipelineWritesPerUnitOutput(t *testing.T) {
+	// Exercises the full producer/splitter/writer pipeline, including
+	// unitRegistry.closeAll, over a small CSV input with two result units.
+	// Without closeAll the writer goroutines' `for range w.lines` loops
+	// never see their channel close, and wg.Wait never returns, so this
+	// test hanging (rather than failing) is what a regression looks like.
+	inFile := filepath.Join(t.TempDir(), "in.csv")
+	csv := "1;2024-08-01;;;;;;\n" +
+		";2024-08-01;1910;100.00;;Ztyret;;\n" +
+		";2024-08-01;3000;;100.00;Ztyret;;\n" +
+		"2;2024-08-01;;;;;;\n" +
+		";2024-08-01;1910;50.00;;Revisorer;;\n" +
+		";2024-08-01;3000;;50.00;Revisorer;;\n"
+	if err := os.WriteFile(inFile, []byte(csv), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	outDir := t.TempDir()
+	cfg := &Config{DefaultResultUnit: "Ztyret", Aggregations: map[string]string{"Revisorer": "Ztyret"}}
+
+	report, err := runPipeline(inFile, outDir, "auto", "csv", "source", "", false, cfg)
+	if err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	if report.VerifikatCount != 2 {
+		t.Errorf("report.VerifikatCount = %d, want 2", report.VerifikatCount)
+	}
+	if len(report.Issues) != 0 {
+		t.Errorf("report.Issues = %v, want none", report.Issues)
+	}
+
+	ztyretFile := filepath.Join(outDir, "Ztyret", "13. Verifikatlista.csv")
+	if _, err := os.Stat(ztyretFile); err != nil {
+		t.Errorf("expected output file for Ztyret: %v", err)
+	}
+	revisorerFile := filepath.Join(outDir, "Ztyret", "Revisorer.csv")
+	if _, err := os.Stat(revisorerFile); err != nil {
+		t.Errorf("expected output file for Revisorer (aggregated into Ztyret's dir): %v", err)
+	}
+}
+
+func drainAll(ch <-chan Line) []Line {
+	lines := make([]Line, 0)
+	for l := range ch {
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+func TestSortedLinesBySourceIsUnbuffered(t *testing.T) {
+	in := make(chan Line, 2)
+	in <- Line{"", "2024-08-02", "b", "", "", "", "", ""}
+	in <- Line{"", "2024-08-01", "a", "", "", "", "", ""}
+	close(in)
+
+	out := sortedLines(in, "csv", "source")
+	got := drainAll(out)
+	if len(got) != 2 || got[0][2] != "b" || got[1][2] != "a" {
+		t.Errorf("sortedLines with --sort=source reordered rows: %v", got)
+	}
+}
+
+func TestSortedLinesByDate(t *testing.T) {
+	in := make(chan Line, 2)
+	in <- Line{"", "2024-08-02", "b", "", "", "", "", ""}
+	in <- Line{"", "2024-08-01", "a", "", "", "", "", ""}
+	close(in)
+
+	out := sortedLines(in, "csv", "date")
+	got := drainAll(out)
+	if len(got) != 2 || got[0][2] != "a" || got[1][2] != "b" {
+		t.Errorf("sortedLines with --sort=date didn't sort by column 1: %v", got)
+	}
+}
+
+func TestSortedLinesIgnoresSortModeForSIE4(t *testing.T) {
+	// #VER/#TRANS grouping in the SIE 4 output depends on arrival order, so
+	// --sort=name/date must not reorder rows for that format.
+	in := make(chan Line, 2)
+	in <- Line{"", "2024-08-02", "b", "", "", "", "", ""}
+	in <- Line{"", "2024-08-01", "a", "", "", "", "", ""}
+	close(in)
+
+	out := sortedLines(in, "sie4", "date")
+	got := drainAll(out)
+	if len(got) != 2 || got[0][2] != "b" || got[1][2] != "a" {
+		t.Errorf("sortedLines reordered rows for --format=sie4, want source order preserved: %v", got)
+	}
+}
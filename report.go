@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Issue is one validation problem found in a single verifikat while
+// splitting the input file.
+type Issue struct {
+	Verifikat string `json:"verifikat"`
+	Kind      string `json:"kind"`
+	Message   string `json:"message"`
+}
+
+// Report collects the Issues found while splitting the input file, so a
+// malformed row or an out-of-balance verifikat doesn't abort the whole
+// export the way a bare log.Fatal used to - the tool can finish the run and
+// let a nightly job gate on whether anything was found.
+type Report struct {
+	Generated      time.Time `json:"generated"`
+	VerifikatCount int       `json:"verifikatCount"`
+	Issues         []Issue   `json:"issues"`
+}
+
+func newReport() *Report {
+	return &Report{Generated: time.Now()}
+}
+
+func (r *Report) addIssue(issue Issue) {
+	r.Issues = append(r.Issues, issue)
+}
+
+// writeReport renders r per mode ("json", "text" or "none"): json writes
+// report.json into outDir, text prints a human summary to stderr, and none
+// suppresses both. Validation issues still gate the process's exit code
+// regardless of mode; mode only controls how they're surfaced.
+func writeReport(r *Report, mode, outDir string) error {
+	switch mode {
+	case "json":
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path.Join(outDir, "report.json"), data, 0664)
+	case "text":
+		return writeReportText(r, os.Stderr)
+	case "none":
+		return nil
+	default:
+		return fmt.Errorf("unknown report mode %q", mode)
+	}
+}
+
+func writeReportText(r *Report, w io.Writer) error {
+	if len(r.Issues) == 0 {
+		_, err := fmt.Fprintf(w, "Validation report: %d verifikat checked, no issues found\n", r.VerifikatCount)
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Validation report: %d verifikat checked, %d issue(s) found\n", r.VerifikatCount, len(r.Issues)); err != nil {
+		return err
+	}
+	for _, issue := range r.Issues {
+		if _, err := fmt.Fprintf(w, "  verifikat %s: [%s] %s\n", issue.Verifikat, issue.Kind, issue.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkVerifikat validates one complete verifikat's worth of lines: debit
+// and credit must sum to zero, every line carrying an amount must name a
+// result unit cfg actually recognises, and no verifikat may cross the
+// fiscal-year boundary implied by its dates. Lines that don't have the
+// expected 8 columns are reported by splitStream as they're read and never
+// reach here.
+func checkVerifikat(verID string, buffer []Line, cfg *Config) []Issue {
+	var issues []Issue
+	var debitSum, creditSum float64
+	var minDate, maxDate string
+
+	for _, line := range buffer {
+		if debit, err := parseReportAmount(line[3]); err == nil {
+			debitSum += debit
+		}
+		if credit, err := parseReportAmount(line[4]); err == nil {
+			creditSum += credit
+		}
+		if (line[3] != "" || line[4] != "") && !cfg.isKnownResultUnit(line[5]) {
+			issues = append(issues, Issue{
+				Verifikat: verID,
+				Kind:      "owner",
+				Message:   fmt.Sprintf("debit/credit line has no recognised result unit in column 6 (got %q)", line[5]),
+			})
+		}
+		if line[1] != "" {
+			if minDate == "" || line[1] < minDate {
+				minDate = line[1]
+			}
+			if maxDate == "" || line[1] > maxDate {
+				maxDate = line[1]
+			}
+		}
+	}
+
+	if diff := debitSum - creditSum; diff > 0.005 || diff < -0.005 {
+		issues = append(issues, Issue{
+			Verifikat: verID,
+			Kind:      "balance",
+			Message:   fmt.Sprintf("debit %.2f does not equal credit %.2f", debitSum, creditSum),
+		})
+	}
+	if minDate != "" && maxDate != "" && fiscalYear(minDate) != fiscalYear(maxDate) {
+		issues = append(issues, Issue{
+			Verifikat: verID,
+			Kind:      "fiscal-year",
+			Message:   fmt.Sprintf("spans a fiscal-year boundary (%s .. %s)", minDate, maxDate),
+		})
+	}
+	return issues
+}
+
+func parseReportAmount(amount string) (float64, error) {
+	if amount == "" {
+		return 0, fmt.Errorf("empty amount")
+	}
+	return strconv.ParseFloat(strings.ReplaceAll(amount, ",", "."), 64)
+}
+
+// fiscalYear returns the label of the Jul-Jun fiscal year a "YYYY-MM-DD"
+// date falls in, e.g. both 2024-08-01 and 2025-05-01 return 2024.
+func fiscalYear(date string) int {
+	parts := strings.SplitN(date, "-", 3)
+	if len(parts) < 2 {
+		return 0
+	}
+	year, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0
+	}
+	month, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0
+	}
+	if month >= 7 {
+		return year
+	}
+	return year - 1
+}
@@ -0,0 +1,95 @@
+package main
+
+import "testing"
+
+func TestFiscalYear(t *testing.T) {
+	tests := []struct {
+		date string
+		want int
+	}{
+		{"2024-08-01", 2024},
+		{"2025-06-30", 2024},
+		{"2025-07-01", 2025},
+		{"2024-01-01", 2023},
+		{"not-a-date", 0},
+		{"2024", 0},
+	}
+	for _, tt := range tests {
+		if got := fiscalYear(tt.date); got != tt.want {
+			t.Errorf("fiscalYear(%q) = %d, want %d", tt.date, got, tt.want)
+		}
+	}
+}
+
+func TestCheckVerifikatBalance(t *testing.T) {
+	cfg := &Config{DefaultResultUnit: "Ztyret"}
+	buffer := []Line{
+		{"1", "2024-08-01", "1910", "100.00", "", "Ztyret", "", ""},
+		{"", "2024-08-01", "3000", "", "100.00", "Ztyret", "", ""},
+	}
+	if issues := checkVerifikat("1", buffer, cfg); len(issues) != 0 {
+		t.Errorf("checkVerifikat on a balanced verifikat returned issues: %v", issues)
+	}
+
+	unbalanced := []Line{
+		{"2", "2024-08-01", "1910", "100.00", "", "Ztyret", "", ""},
+		{"", "2024-08-01", "3000", "", "50.00", "Ztyret", "", ""},
+	}
+	issues := checkVerifikat("2", unbalanced, cfg)
+	if !hasIssueKind(issues, "balance") {
+		t.Errorf("checkVerifikat on an unbalanced verifikat = %v, want a \"balance\" issue", issues)
+	}
+}
+
+func TestCheckVerifikatMissingOwner(t *testing.T) {
+	cfg := &Config{DefaultResultUnit: "Ztyret"}
+	buffer := []Line{
+		{"1", "2024-08-01", "1910", "100.00", "", "", "", ""},
+		{"", "2024-08-01", "3000", "", "100.00", "Ztyret", "", ""},
+	}
+	issues := checkVerifikat("1", buffer, cfg)
+	if !hasIssueKind(issues, "owner") {
+		t.Errorf("checkVerifikat on a debit line with no owner = %v, want an \"owner\" issue", issues)
+	}
+}
+
+func TestCheckVerifikatUnrecognisedOwner(t *testing.T) {
+	cfg := &Config{DefaultResultUnit: "Ztyret", Aggregations: map[string]string{"Revisorer": "Ztyret"}}
+	buffer := []Line{
+		{"1", "2024-08-01", "1910", "100.00", "", "NotARealUnit", "", ""},
+		{"", "2024-08-01", "3000", "", "100.00", "NotARealUnit", "", ""},
+	}
+	issues := checkVerifikat("1", buffer, cfg)
+	if !hasIssueKind(issues, "owner") {
+		t.Errorf("checkVerifikat with an owner cfg doesn't recognise = %v, want an \"owner\" issue", issues)
+	}
+
+	recognised := []Line{
+		{"2", "2024-08-01", "1910", "100.00", "", "Revisorer", "", ""},
+		{"", "2024-08-01", "3000", "", "100.00", "Revisorer", "", ""},
+	}
+	if issues := checkVerifikat("2", recognised, cfg); hasIssueKind(issues, "owner") {
+		t.Errorf("checkVerifikat with an aggregation-source owner = %v, want no \"owner\" issue", issues)
+	}
+}
+
+func TestCheckVerifikatFiscalYearBoundary(t *testing.T) {
+	cfg := &Config{DefaultResultUnit: "Ztyret"}
+	buffer := []Line{
+		{"1", "2024-06-30", "1910", "100.00", "", "Ztyret", "", ""},
+		{"", "2024-07-01", "3000", "", "100.00", "Ztyret", "", ""},
+	}
+	issues := checkVerifikat("1", buffer, cfg)
+	if !hasIssueKind(issues, "fiscal-year") {
+		t.Errorf("checkVerifikat spanning a fiscal-year boundary = %v, want a \"fiscal-year\" issue", issues)
+	}
+}
+
+func hasIssueKind(issues []Issue, kind string) bool {
+	for _, issue := range issues {
+		if issue.Kind == kind {
+			return true
+		}
+	}
+	return false
+}